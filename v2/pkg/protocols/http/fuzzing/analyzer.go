@@ -2,19 +2,63 @@ package fuzzing
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"net/url"
+	"runtime/debug"
 	"strings"
+	"sync"
 
 	jsoniter "github.com/json-iterator/go"
 	"github.com/morikuni/accessor"
 	"github.com/pkg/errors"
 	"github.com/projectdiscovery/gologger"
+	"github.com/projectdiscovery/nuclei/v2/pkg/protocols/http/fuzzing/bodycodec"
+	"golang.org/x/time/rate"
 )
 
+// AnalyzerPanicError wraps a panic recovered from a body analyzer or the
+// fuzzing callback into a structured error.
+//
+// The body analyzers call into third-party document libraries (the JSON/XML
+// accessor in particular) that can panic on malformed or adversarial input.
+// Surfacing the recovered value as a regular error, along with the transform
+// that triggered it, lets the scan continue instead of crashing the whole
+// run.
+type AnalyzerPanicError struct {
+	// Transform is the name of the analyzer or dispatch stage that panicked.
+	Transform string
+	// Part is the request part the transform targeted.
+	Part string
+	// Key is the key the transform targeted.
+	Key string
+	// Recovered is the original value passed to panic.
+	Recovered interface{}
+	// Stack is the stack trace captured at the point of recovery.
+	Stack []byte
+}
+
+// Error returns a human readable representation of the panic.
+func (e *AnalyzerPanicError) Error() string {
+	return fmt.Sprintf("panic in %s (part=%s, key=%s): %v\n%s", e.Transform, e.Part, e.Key, e.Recovered, e.Stack)
+}
+
+// recoverAnalyzerPanic builds an *AnalyzerPanicError from a recovered value,
+// tagging it with the transform stage and target it occurred in.
+func recoverAnalyzerPanic(stage string, transform *Transform, recovered interface{}) error {
+	return &AnalyzerPanicError{
+		Transform: stage,
+		Part:      transform.Part,
+		Key:       transform.Key,
+		Recovered: recovered,
+		Stack:     debug.Stack(),
+	}
+}
+
 // AnalyzerOptions contains configuration options for the injection
 // point analyzer.
 type AnalyzerOptions struct {
@@ -52,6 +96,48 @@ type AnalyzerOptions struct {
 	// Keys are the values provided by the parts field of the configuration.
 	// Values contains configuration options for choosing the said part.
 	PartsConfig map[string][]*AnalyzerPartsConfig `yaml:"parts-config"`
+
+	// ReproducerSink, when set, receives a newline-delimited JSON
+	// ReproducerRecord for every transform applied during AnalyzeRequest.
+	//
+	// Each record contains the pre-mutation request, the transform that was
+	// applied, and the final request as it was handed to the callback, which
+	// is enough to reproduce or triage a single fuzzing iteration outside of
+	// a live scan.
+	ReproducerSink io.Writer `yaml:"-"`
+
+	// Schema, when set, restricts fuzzing to the injection points declared
+	// by the OpenAPI 3 / Swagger 2 operation matching the request's method
+	// and path. Load one with LoadSchemaFromFile or LoadSchemaFromURL.
+	Schema *Schema `yaml:"-"`
+
+	// Concurrency is the number of transforms dispatched in parallel by
+	// AnalyzeRequest. Values less than 1 are treated as 1, which keeps the
+	// previous fully-serial behavior.
+	Concurrency int `yaml:"concurrency"`
+
+	// RatePerSecond caps how many requests per second AnalyzeRequest invokes
+	// the callback at, across all workers. Zero disables rate limiting.
+	RatePerSecond int `yaml:"rate-per-second"`
+
+	// OnError, when set, is called with every transform that panicked and
+	// its recovered *AnalyzerPanicError, in addition to the panic being
+	// logged. AnalyzeRequest always continues on to the remaining
+	// transforms regardless of whether OnError is set.
+	OnError func(transform *Transform, err error) `yaml:"-"`
+
+	// PromoteGraphQLVariables, when set, rewrites every inline argument
+	// literal in a GraphQL request's query into a $variable reference before
+	// fuzzing, moving its value into the variables map. Some servers reject
+	// injection attempts embedded directly in the query document but accept
+	// the same payload through variables, so promoting first keeps payloads
+	// round-tripping cleanly.
+	PromoteGraphQLVariables bool `yaml:"promote-graphql-variables"`
+
+	// reproducerMu serializes writes to ReproducerSink across the worker
+	// pool, since io.Writer implementations aren't generally safe for
+	// concurrent use.
+	reproducerMu sync.Mutex
 }
 
 // AnalyzeRequest analyzes a normalized request with an analyzer
@@ -64,90 +150,316 @@ type AnalyzerOptions struct {
 // choose whether this field can be fuzzed or not. If the part can be fuzzed, testing
 // is finally performed for the request.
 func AnalyzeRequest(req *NormalizedRequest, options *AnalyzerOptions, callback func(*http.Request)) error {
+	// If the body arrived compressed, decode it into the typed representation
+	// the body analyzers expect so that fuzzing still sees structured data,
+	// not a blob of compressed bytes.
+	contentEncoding := req.Headers.Get("Content-Encoding")
+	if contentEncoding != "" && req.Body != "" {
+		if decodeErr := decodeCompressedBody(req, contentEncoding); decodeErr != nil {
+			gologger.Warning().Msgf("Could not decode %s-encoded body for fuzzing: %s\n", contentEncoding, decodeErr)
+			contentEncoding = ""
+		}
+	}
+
+	if options.PromoteGraphQLVariables && req.GraphQLData != nil {
+		promoteGraphQLVariables(req.GraphQLData)
+	}
+
+	transforms := options.enrichTransformsFromSchema(req, options.restrictTransformsToSchema(req, CreateTransform(req, options)))
+
+	concurrency := options.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var limiter *rate.Limiter
+	if options.RatePerSecond > 0 {
+		limiter = rate.NewLimiter(rate.Limit(options.RatePerSecond), options.RatePerSecond)
+	}
+
+	jobs := make(chan sequencedTransform)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if limiter != nil {
+					if waitErr := limiter.Wait(context.Background()); waitErr != nil {
+						gologger.Warning().Msgf("Could not wait for rate limiter: %s\n", waitErr)
+						continue
+					}
+				}
+
+				newRequest, err := options.buildRequestForTransform(req, job.transform, contentEncoding, job.seq)
+				if err != nil {
+					gologger.Warning().Msgf("Could not create request for fuzzing: %s\n", err)
+					continue
+				}
+				if cbErr := invokeCallback(callback, newRequest, job.transform); cbErr != nil {
+					gologger.Warning().Msgf("Recovered from panic invoking fuzzing callback: %s\n", cbErr)
+					if options.OnError != nil {
+						options.OnError(job.transform, cbErr)
+					}
+				}
+			}
+		}()
+	}
+
+	for i, transform := range transforms {
+		jobs <- sequencedTransform{seq: i, transform: transform}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return nil
+}
+
+// sequencedTransform pairs a transform with its position in the original,
+// deterministically-ordered transforms slice, since the worker pool that
+// dispatches them completes out of order.
+type sequencedTransform struct {
+	seq       int
+	transform *Transform
+}
+
+// transformSequenceKey is the context key buildRequestForTransform stores a
+// transform's sequence number under.
+type transformSequenceKey struct{}
+
+// TransformSequence returns the sequence number AnalyzeRequest assigned to
+// the transform that produced req, in the order transforms were computed for
+// the request. It lets a callback or downstream matcher correlate results
+// back to their transform even though the worker pool dispatches requests
+// out of order.
+func TransformSequence(req *http.Request) (int, bool) {
+	seq, ok := req.Context().Value(transformSequenceKey{}).(int)
+	return seq, ok
+}
+
+// buildRequestForTransform renders the *http.Request for a single transform.
+// It is safe to call concurrently for the same req: every call builds its
+// own body buffer, multipart writer and URL/cookie builder instead of
+// sharing them across transforms. This includes JSON and XML bodies —
+// accessor.NewAccessor copies req.JSONData/req.XMLData into its own tree
+// before analyzeJSONBody/analyzeXMLBody call Set, so concurrent calls don't
+// alias or mutate the request's original body. A transform targeting "path"
+// is only honored when o.Schema resolved it to a concrete path segment via
+// substitutePathParam; otherwise req.Path is used verbatim. seq is stamped
+// onto the returned request's context so TransformSequence can recover it
+// later, regardless of the order the worker pool finishes requests in.
+func (o *AnalyzerOptions) buildRequestForTransform(req *NormalizedRequest, transform *Transform, contentEncoding string, seq int) (*http.Request, error) {
 	var reqBody io.ReadCloser
 	var contentType string
 	var contentLength int
 	var err error
 
-	transforms := CreateTransform(req, options)
+	// If we have multipart body, add it to the request.
+	if len(req.MultipartBody) > 0 {
+		reqBody, contentLength, contentType, err = o.analyzeMultipartBody(req, transform)
+	}
+	// If we have form data body, add it to the request.
+	if len(req.FormData) > 0 {
+		reqBody, contentLength, contentType, err = o.analyzeFormBody(req, transform)
+	}
+	// If we have JSON data body, add it to the request.
+	if req.JSONData != nil {
+		reqBody, contentLength, contentType, err = o.analyzeJSONBody(req, transform)
+	}
+	// If we have XML data body, add it to the request.
+	if len(req.XMLData) > 0 {
+		reqBody, contentLength, contentType, err = o.analyzeXMLBody(req, transform)
+	}
+	// If we have a GraphQL body, add it to the request.
+	if req.GraphQLData != nil {
+		reqBody, contentLength, contentType, err = o.analyzeGraphQLBody(req, transform)
+	}
+	if req.Body != "" {
+		reqBody = ioutil.NopCloser(strings.NewReader(req.Body))
+		contentLength = len(req.Body)
+		contentType = req.Headers.Get("Content-Type")
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	for _, transform := range transforms {
-		// If we have multipart body, add it to the request.
-		if len(req.MultipartBody) > 0 {
-			reqBody, contentLength, contentType, err = options.analyzeMultipartBody(req, transform)
-		}
-		// If we have form data body, add it to the request.
-		if len(req.FormData) > 0 {
-			reqBody, contentLength, contentType, err = options.analyzeFormBody(req, transform)
-		}
-		// If we have JSON data body, add it to the request.
-		if req.JSONData != nil {
-			reqBody, contentLength, contentType, err = options.analyzeJSONBody(req, transform)
-		}
-		// If we have XML data body, add it to the request.
-		if len(req.XMLData) > 0 {
-			reqBody, contentLength, contentType, err = options.analyzeXMLBody(req, transform)
+	if contentEncoding != "" && reqBody != nil {
+		reqBody, contentLength, err = recompressBody(contentEncoding, reqBody)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not recompress body for fuzzing")
 		}
-		if req.Body != "" {
-			reqBody = ioutil.NopCloser(strings.NewReader(req.Body))
-			contentLength = len(req.Body)
-			contentType = req.Headers.Get("Content-Type")
+	}
+
+	var bodyRecorder *bytes.Buffer
+	if o.ReproducerSink != nil && reqBody != nil {
+		bodyRecorder = &bytes.Buffer{}
+		reqBody = ioutil.NopCloser(io.TeeReader(reqBody, bodyRecorder))
+	}
+
+	path := req.Path
+	if transform.Part == "path" && o.Schema != nil {
+		if fuzzedPath, ok := o.Schema.substitutePathParam(req.Method, req.Path, transform.Key, transform.Value); ok {
+			path = fuzzedPath
 		}
-		if err != nil {
-			gologger.Warning().Msgf("Could not create request for fuzzing: %s\n", err)
-			continue
+	}
+
+	builder := &strings.Builder{}
+	builder.WriteString(req.Scheme)
+	builder.WriteString("://")
+	builder.WriteString(req.Host)
+	builder.WriteString(path)
+	newRequest, err := http.NewRequest(req.Method, builder.String(), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	query := &url.Values{}
+	for k, v := range req.QueryValues {
+		for _, value := range v {
+			query.Add(k, value)
 		}
+	}
+	newRequest.URL.RawQuery = query.Encode()
 
-		builder := &strings.Builder{}
-		builder.WriteString(req.Scheme)
-		builder.WriteString("://")
-		builder.WriteString(req.Host)
-		builder.WriteString(req.Path)
-		newRequest, err := http.NewRequest(req.Method, builder.String(), reqBody)
-		if err != nil {
-			return err
+	for k, v := range req.Headers {
+		for _, value := range v {
+			newRequest.Header.Add(k, value)
 		}
-		query := &url.Values{}
-		for k, v := range req.QueryValues {
-			for _, value := range v {
-				query.Add(k, value)
-			}
+	}
+	if req.Headers.Get("Content-Length") != "" && contentLength != 0 {
+		newRequest.ContentLength = int64(contentLength)
+	}
+	if contentType != "" {
+		newRequest.Header.Set("Content-Type", contentType)
+	}
+
+	builder.Reset()
+	for k, v := range req.Cookies {
+		for _, value := range v {
+			builder.WriteString(k)
+			builder.WriteString("=")
+			builder.WriteString(value)
+			builder.WriteString(";")
+			builder.WriteString(" ")
 		}
-		newRequest.URL.RawQuery = query.Encode()
+	}
+	cookieString := strings.TrimSpace(builder.String())
+	if cookieString != "" {
+		newRequest.Header.Set("Cookie", cookieString)
+	}
 
-		for k, v := range req.Headers {
-			for _, value := range v {
-				newRequest.Header.Add(k, value)
-			}
+	if o.ReproducerSink != nil {
+		if bodyRecorder != nil {
+			_, _ = io.Copy(ioutil.Discard, newRequest.Body)
+			newRequest.Body = ioutil.NopCloser(bytes.NewReader(bodyRecorder.Bytes()))
 		}
-		if req.Headers.Get("Content-Length") != "" && contentLength != 0 {
-			newRequest.ContentLength = int64(contentLength)
+		o.reproducerMu.Lock()
+		recErr := o.writeReproducerRecord(req, transform, newRequest, bodyRecorder)
+		o.reproducerMu.Unlock()
+		if recErr != nil {
+			gologger.Warning().Msgf("Could not write reproducer record: %s\n", recErr)
 		}
-		if contentType != "" {
-			newRequest.Header.Set("Content-Type", contentType)
+	}
+
+	newRequest = newRequest.WithContext(context.WithValue(newRequest.Context(), transformSequenceKey{}, seq))
+
+	return newRequest, nil
+}
+
+// invokeCallback invokes callback with newRequest, recovering from any panic
+// raised by the caller-supplied callback and converting it to an error tagged
+// with the transform that was being dispatched.
+func invokeCallback(callback func(*http.Request), newRequest *http.Request, transform *Transform) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoverAnalyzerPanic("callback", transform, r)
 		}
+	}()
+	callback(newRequest)
+	return nil
+}
 
-		builder.Reset()
-		for k, v := range req.Cookies {
-			for _, value := range v {
-				builder.WriteString(k)
-				builder.WriteString("=")
-				builder.WriteString(value)
-				builder.WriteString(";")
-				builder.WriteString(" ")
-			}
+// decodeCompressedBody decodes req.Body using encoding and assigns the
+// result to whichever typed body field matches req's Content-Type, so the
+// regular body analyzers can fuzz it like any other structured body. req.Body
+// is cleared once a typed field has been populated.
+//
+// JSON and form bodies are recognised by their Content-Type and decoded into
+// JSONData/FormData respectively. Multipart and XML bodies require the same
+// structural parsing the request normalizer performs on an uncompressed
+// body, which this function doesn't duplicate; those are left as a decoded
+// raw body with a warning, since req.MultipartBody/req.XMLData stay empty
+// and CreateTransform won't generate body-key transforms for them.
+func decodeCompressedBody(req *NormalizedRequest, encoding string) error {
+	decoded, err := bodycodec.Decode(encoding, strings.NewReader(req.Body))
+	if err != nil {
+		return errors.Wrap(err, "could not decode body")
+	}
+	defer decoded.Close()
+
+	data, err := ioutil.ReadAll(decoded)
+	if err != nil {
+		return errors.Wrap(err, "could not read decoded body")
+	}
+
+	switch contentType := req.Headers.Get("Content-Type"); {
+	case strings.Contains(contentType, "json"):
+		var value interface{}
+		if err := jsoniter.Unmarshal(data, &value); err != nil {
+			return errors.Wrap(err, "could not unmarshal decoded json body")
 		}
-		cookieString := strings.TrimSpace(builder.String())
-		if cookieString != "" {
-			newRequest.Header.Set("Cookie", cookieString)
+		req.JSONData = value
+		req.Body = ""
+	case strings.Contains(contentType, "form-urlencoded"):
+		values, err := url.ParseQuery(string(data))
+		if err != nil {
+			return errors.Wrap(err, "could not parse decoded form body")
 		}
-		callback(newRequest)
+		req.FormData = values
+		req.Body = ""
+	case strings.Contains(contentType, "multipart"), strings.Contains(contentType, "xml"):
+		gologger.Warning().Msgf("Decoded %s-encoded %s body won't be structurally fuzzed, only replayed as-is\n", encoding, contentType)
+		req.Body = string(data)
+	default:
+		req.Body = string(data)
 	}
 	return nil
 }
 
+// recompressBody reads body fully and recompresses it with encoding,
+// returning a fresh reader over the compressed bytes along with their
+// length, so Content-Length stays accurate after fuzzing.
+func recompressBody(encoding string, body io.ReadCloser) (io.ReadCloser, int, error) {
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "could not read rendered body")
+	}
+
+	buffer := &bytes.Buffer{}
+	writer, err := bodycodec.Encode(encoding, buffer)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "could not create body encoder")
+	}
+	if _, err := writer.Write(raw); err != nil {
+		return nil, 0, errors.Wrap(err, "could not compress rendered body")
+	}
+	if err := writer.Close(); err != nil {
+		return nil, 0, errors.Wrap(err, "could not flush compressed body")
+	}
+	return ioutil.NopCloser(buffer), buffer.Len(), nil
+}
+
 // analyzeMultipartBody analyzes multipart body and also fuzzes if asked.
-func (o *AnalyzerOptions) analyzeMultipartBody(req *NormalizedRequest, transform *Transform) (io.ReadCloser, int, string, error) {
+func (o *AnalyzerOptions) analyzeMultipartBody(req *NormalizedRequest, transform *Transform) (reqBody io.ReadCloser, contentLength int, contentType string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr := recoverAnalyzerPanic("multipart", transform, r)
+			reqBody, contentLength, contentType, err = nil, 0, "", panicErr
+			if o.OnError != nil {
+				o.OnError(transform, panicErr)
+			}
+		}
+	}()
+
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
 
@@ -179,7 +491,17 @@ func (o *AnalyzerOptions) analyzeMultipartBody(req *NormalizedRequest, transform
 }
 
 // analyzeFormBody analyzes form body and also fuzzes if asked.
-func (o *AnalyzerOptions) analyzeFormBody(req *NormalizedRequest, transform *Transform) (io.ReadCloser, int, string, error) {
+func (o *AnalyzerOptions) analyzeFormBody(req *NormalizedRequest, transform *Transform) (reqBody io.ReadCloser, contentLength int, contentType string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr := recoverAnalyzerPanic("form", transform, r)
+			reqBody, contentLength, contentType, err = nil, 0, "", panicErr
+			if o.OnError != nil {
+				o.OnError(transform, panicErr)
+			}
+		}
+	}()
+
 	data := url.Values{}
 
 	for k, v := range req.FormData {
@@ -195,7 +517,17 @@ func (o *AnalyzerOptions) analyzeFormBody(req *NormalizedRequest, transform *Tra
 }
 
 // analyzeJSONBody analyzes json body and also fuzzes if asked.
-func (o *AnalyzerOptions) analyzeJSONBody(req *NormalizedRequest, transform *Transform) (io.ReadCloser, int, string, error) {
+func (o *AnalyzerOptions) analyzeJSONBody(req *NormalizedRequest, transform *Transform) (reqBody io.ReadCloser, contentLength int, contentType string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr := recoverAnalyzerPanic("json", transform, r)
+			reqBody, contentLength, contentType, err = nil, 0, "", panicErr
+			if o.OnError != nil {
+				o.OnError(transform, panicErr)
+			}
+		}
+	}()
+
 	acc, err := accessor.NewAccessor(req.JSONData)
 	if err != nil {
 		return nil, 0, "", errors.Wrap(err, "could not access json data")
@@ -220,7 +552,17 @@ func (o *AnalyzerOptions) analyzeJSONBody(req *NormalizedRequest, transform *Tra
 }
 
 // analyzeXMLBody analyzes xml body and also fuzzes if asked.
-func (o *AnalyzerOptions) analyzeXMLBody(req *NormalizedRequest, transform *Transform) (io.ReadCloser, int, string, error) {
+func (o *AnalyzerOptions) analyzeXMLBody(req *NormalizedRequest, transform *Transform) (reqBody io.ReadCloser, contentLength int, contentType string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr := recoverAnalyzerPanic("xml", transform, r)
+			reqBody, contentLength, contentType, err = nil, 0, "", panicErr
+			if o.OnError != nil {
+				o.OnError(transform, panicErr)
+			}
+		}
+	}()
+
 	acc, err := accessor.NewAccessor(req.XMLData)
 	if err != nil {
 		return nil, 0, "", errors.Wrap(err, "could not access XML data")
@@ -241,4 +583,4 @@ func (o *AnalyzerOptions) analyzeXMLBody(req *NormalizedRequest, transform *Tran
 		return nil, 0, "", errors.Wrap(err, "could not write xml data")
 	}
 	return ioutil.NopCloser(buffer), buffer.Len(), "text/xml", nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,79 @@
+package fuzzing
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// TestAnalyzeRequestConcurrentJSONBody drives AnalyzeRequest with
+// Concurrency > 1 against a JSON body that has one transform per field, so
+// every worker renders a different body concurrently from the same
+// req.JSONData. Run with -race, it also asserts that each rendered body only
+// differs from the original in the one field its own transform targeted and
+// that req.JSONData itself is untouched afterwards, which is what would
+// break if analyzeJSONBody ever stopped getting its own accessor copy.
+func TestAnalyzeRequestConcurrentJSONBody(t *testing.T) {
+	original := map[string]interface{}{
+		"name":  "alice",
+		"email": "alice@example.com",
+		"age":   "30",
+	}
+	req := &NormalizedRequest{
+		Method:   http.MethodPost,
+		Scheme:   "https",
+		Host:     "example.com",
+		Path:     "/users",
+		Headers:  http.Header{"Content-Type": []string{"application/json"}},
+		JSONData: original,
+	}
+
+	options := &AnalyzerOptions{
+		Parts:       []string{"body"},
+		Concurrency: 8,
+	}
+
+	var (
+		mu    sync.Mutex
+		calls int
+	)
+	err := AnalyzeRequest(req, options, func(r *http.Request) {
+		defer r.Body.Close()
+		raw, readErr := ioutil.ReadAll(r.Body)
+		if readErr != nil {
+			t.Errorf("could not read rendered body: %s", readErr)
+			return
+		}
+		var rendered map[string]interface{}
+		if jsonErr := json.Unmarshal(raw, &rendered); jsonErr != nil {
+			t.Errorf("could not unmarshal rendered body: %s", jsonErr)
+			return
+		}
+
+		changed := 0
+		for key, value := range original {
+			if !reflect.DeepEqual(rendered[key], value) {
+				changed++
+			}
+		}
+		if changed > 1 {
+			t.Errorf("rendered body changed %d fields, want at most 1: %#v", changed, rendered)
+		}
+
+		mu.Lock()
+		calls++
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatalf("AnalyzeRequest returned error: %s", err)
+	}
+	if calls == 0 {
+		t.Fatal("expected at least one fuzzing callback invocation")
+	}
+	if !reflect.DeepEqual(req.JSONData, original) {
+		t.Fatalf("req.JSONData was mutated by fuzzing: %#v", req.JSONData)
+	}
+}
@@ -0,0 +1,70 @@
+// Package bodycodec implements transparent decompression and recompression
+// of HTTP bodies for the Content-Encoding tokens the fuzzing analyzer needs
+// to see through in order to mutate a compressed body.
+package bodycodec
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Decode returns a ReadCloser that transparently decompresses r according to
+// encoding, a Content-Encoding token (gzip, deflate, br or zstd, matched
+// case-insensitively). An empty encoding is a no-op passthrough.
+func Decode(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch strings.ToLower(encoding) {
+	case "":
+		return ioutil.NopCloser(r), nil
+	case "gzip":
+		reader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not create gzip reader")
+		}
+		return reader, nil
+	case "deflate":
+		return flate.NewReader(r), nil
+	case "br":
+		return ioutil.NopCloser(brotli.NewReader(r)), nil
+	case "zstd":
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not create zstd reader")
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, errors.Errorf("unsupported content-encoding %q", encoding)
+	}
+}
+
+// Encode returns a WriteCloser that compresses writes into w according to
+// encoding, mirroring the encodings Decode accepts. Close must be called to
+// flush the compressed stream.
+func Encode(encoding string, w io.Writer) (io.WriteCloser, error) {
+	switch strings.ToLower(encoding) {
+	case "":
+		return nopWriteCloser{w}, nil
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "deflate":
+		return flate.NewWriter(w, flate.DefaultCompression)
+	case "br":
+		return brotli.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w)
+	default:
+		return nil, errors.Errorf("unsupported content-encoding %q", encoding)
+	}
+}
+
+// nopWriteCloser adapts an io.Writer to io.WriteCloser for the empty
+// encoding case, where there is nothing to flush on Close.
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,245 @@
+package fuzzing
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+// GraphQLData is the parsed representation of a GraphQL request body. It is
+// populated on NormalizedRequest.GraphQLData by DetectGraphQLBody whenever a
+// request's Content-Type is application/graphql, or its JSON body matches
+// the canonical {query, variables, operationName} shape.
+type GraphQLData struct {
+	Query         string
+	Variables     map[string]interface{}
+	OperationName string
+}
+
+// DetectGraphQLBody inspects a request body and returns its parsed GraphQL
+// representation when contentType or the body itself identify it as a
+// GraphQL request, so the normalizer can populate
+// NormalizedRequest.GraphQLData.
+func DetectGraphQLBody(contentType string, body []byte) (*GraphQLData, bool) {
+	if strings.Contains(contentType, "application/graphql") {
+		return &GraphQLData{Query: string(body)}, true
+	}
+
+	var payload struct {
+		Query         string                 `json:"query"`
+		Variables     map[string]interface{} `json:"variables"`
+		OperationName string                 `json:"operationName"`
+	}
+	if err := jsoniter.Unmarshal(body, &payload); err != nil || payload.Query == "" {
+		return nil, false
+	}
+	return &GraphQLData{
+		Query:         payload.Query,
+		Variables:     payload.Variables,
+		OperationName: payload.OperationName,
+	}, true
+}
+
+// graphqlArgument is an argument value found inside a GraphQL query
+// document, with the byte span its value occupies so it can be rewritten in
+// place.
+type graphqlArgument struct {
+	Name       string
+	Start, End int
+	Variable   string // non-empty when the argument references $variable
+	Quoted     bool   // true when the value was a quoted string literal
+}
+
+var (
+	graphqlCallPattern = regexp.MustCompile(`\w+\s*\(([^)]*)\)`)
+	graphqlArgPattern  = regexp.MustCompile(`(\w+)\s*:\s*(\$\w+|"(?:[^"\\]|\\.)*"|[\w.+-]+)`)
+)
+
+// lexGraphQLArguments performs a minimal scan of a GraphQL query document,
+// extracting every `name: value` argument pair found inside an
+// `identifier(...)` group, other than the `$name: Type` entries of an
+// operation's variable definitions. It understands string, variable and bare
+// (int/float/enum/bool) values; it does not parse the full GraphQL grammar,
+// so the arguments of fields at any nesting depth and of directives are all
+// found alike, and an argument whose value is an object or list literal is
+// not found itself.
+func lexGraphQLArguments(query string) []graphqlArgument {
+	var args []graphqlArgument
+	for _, call := range graphqlCallPattern.FindAllStringSubmatchIndex(query, -1) {
+		argsStart, argsEnd := call[2], call[3]
+		body := query[argsStart:argsEnd]
+
+		for _, match := range graphqlArgPattern.FindAllStringSubmatchIndex(body, -1) {
+			// A `$name: Type` pair is a variable definition, not an argument.
+			if match[2] > 0 && body[match[2]-1] == '$' {
+				continue
+			}
+			name := body[match[2]:match[3]]
+			valueStart, valueEnd := argsStart+match[4], argsStart+match[5]
+			value := query[valueStart:valueEnd]
+
+			arg := graphqlArgument{Name: name, Start: valueStart, End: valueEnd}
+			switch {
+			case strings.HasPrefix(value, "$"):
+				arg.Variable = strings.TrimPrefix(value, "$")
+			case strings.HasPrefix(value, `"`):
+				// Keep the surrounding quotes in place and only replace the
+				// literal's content, so a fuzzed value can still try to
+				// break out of the string.
+				arg.Start++
+				arg.End--
+				arg.Quoted = true
+			}
+			args = append(args, arg)
+		}
+	}
+	return args
+}
+
+// promoteGraphQLVariables rewrites every inline argument literal in data's
+// query into a $variable reference, moving its value into data.Variables, so
+// later fuzzing always targets variables instead of the query document
+// itself. It processes arguments back-to-front so earlier byte offsets stay
+// valid as the query shrinks or grows.
+func promoteGraphQLVariables(data *GraphQLData) {
+	args := lexGraphQLArguments(data.Query)
+	if len(args) == 0 {
+		return
+	}
+	if data.Variables == nil {
+		data.Variables = make(map[string]interface{}, len(args))
+	}
+
+	query := data.Query
+	for i := len(args) - 1; i >= 0; i-- {
+		arg := args[i]
+		if arg.Variable != "" {
+			// Already a variable reference; nothing to promote.
+			continue
+		}
+
+		name := graphqlPromotedVariableName(data.Variables, arg.Name)
+		data.Variables[name] = parseGraphQLLiteral(query[arg.Start:arg.End], arg.Quoted)
+		// A quoted literal's span excludes its quotes; replace them too.
+		start, end := arg.Start, arg.End
+		if arg.Quoted {
+			start, end = start-1, end+1
+		}
+		query = query[:start] + "$" + name + query[end:]
+	}
+	data.Query = query
+}
+
+// parseGraphQLLiteral converts raw, the source-text span of an argument
+// value lexGraphQLArguments captured, into the Go value it should become in
+// the promoted variables map, so the later JSON encoding of the payload
+// round-trips the literal's original type instead of re-encoding everything
+// as a string. quoted indicates raw was the content of a `"..."` string
+// literal with its surrounding quotes already stripped.
+//
+// Quoted literals are unescaped through a JSON string decode, since GraphQL
+// string escape sequences are a subset of JSON's. Bare literals are tried in
+// turn as a bool, an integer and a float; anything else (enum values,
+// identifiers) is kept as-is, matching how the server would interpret an
+// unquoted token it doesn't recognise as a number or boolean.
+func parseGraphQLLiteral(raw string, quoted bool) interface{} {
+	if quoted {
+		var value string
+		if err := jsoniter.Unmarshal([]byte(`"`+raw+`"`), &value); err == nil {
+			return value
+		}
+		return raw
+	}
+	if raw == "true" || raw == "false" {
+		return raw == "true"
+	}
+	if value, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return value
+	}
+	if value, err := strconv.ParseFloat(raw, 64); err == nil && !math.IsInf(value, 0) && !math.IsNaN(value) {
+		return value
+	}
+	return raw
+}
+
+// graphqlPromotedVariableName returns arg's own name when it isn't already
+// used in variables, otherwise a suffixed variant that is.
+func graphqlPromotedVariableName(variables map[string]interface{}, name string) string {
+	if _, exists := variables[name]; !exists {
+		return name
+	}
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s%d", name, i)
+		if _, exists := variables[candidate]; !exists {
+			return candidate
+		}
+	}
+}
+
+// analyzeGraphQLBody fuzzes a GraphQL request body, targeting either an
+// inline argument literal in the query or, when the argument references a
+// variable, the matching entry in the variables map.
+func (o *AnalyzerOptions) analyzeGraphQLBody(req *NormalizedRequest, transform *Transform) (reqBody io.ReadCloser, contentLength int, contentType string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr := recoverAnalyzerPanic("graphql", transform, r)
+			reqBody, contentLength, contentType, err = nil, 0, "", panicErr
+			if o.OnError != nil {
+				o.OnError(transform, panicErr)
+			}
+		}
+	}()
+
+	query := req.GraphQLData.Query
+	variables := make(map[string]interface{}, len(req.GraphQLData.Variables))
+	for k, v := range req.GraphQLData.Variables {
+		variables[k] = v
+	}
+
+	if transform.Part == "body" {
+		applied := false
+		for _, arg := range lexGraphQLArguments(query) {
+			if !strings.EqualFold(arg.Name, transform.Key) {
+				continue
+			}
+			if arg.Variable != "" {
+				variables[arg.Variable] = transform.Value
+			} else {
+				query = query[:arg.Start] + transform.Value + query[arg.End:]
+			}
+			applied = true
+			break
+		}
+		// The key didn't match an inline argument; if it names a top-level
+		// variable directly, fuzz that instead.
+		if !applied {
+			if _, ok := variables[transform.Key]; ok {
+				variables[transform.Key] = transform.Value
+			}
+		}
+	}
+
+	payload := map[string]interface{}{
+		"query":     query,
+		"variables": variables,
+	}
+	if req.GraphQLData.OperationName != "" {
+		payload["operationName"] = req.GraphQLData.OperationName
+	}
+
+	buffer := &bytes.Buffer{}
+	enc := jsoniter.NewEncoder(buffer)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(payload); err != nil {
+		return nil, 0, "", errors.Wrap(err, "could not write graphql body")
+	}
+	return ioutil.NopCloser(buffer), buffer.Len(), "application/json", nil
+}
@@ -0,0 +1,67 @@
+package fuzzing
+
+import "testing"
+
+func TestParseGraphQLLiteral(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		quoted bool
+		want   interface{}
+	}{
+		{name: "quoted string", raw: "alice", quoted: true, want: "alice"},
+		{name: "quoted string with escapes", raw: `line\nbreak \"quote\"`, quoted: true, want: "line\nbreak \"quote\""},
+		{name: "bare integer", raw: "5", quoted: false, want: int64(5)},
+		{name: "bare negative integer", raw: "-1", quoted: false, want: int64(-1)},
+		{name: "bare float", raw: "1.5", quoted: false, want: float64(1.5)},
+		{name: "bare bool true", raw: "true", quoted: false, want: true},
+		{name: "bare bool false", raw: "false", quoted: false, want: false},
+		{name: "bare enum", raw: "ACTIVE", quoted: false, want: "ACTIVE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseGraphQLLiteral(tt.raw, tt.quoted)
+			if got != tt.want {
+				t.Fatalf("parseGraphQLLiteral(%q, %v) = %#v (%T), want %#v (%T)", tt.raw, tt.quoted, got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromoteGraphQLVariablesPreservesTypes(t *testing.T) {
+	data := &GraphQLData{
+		Query: `query { user(id: "123", count: 5, active: true) { name } }`,
+	}
+
+	promoteGraphQLVariables(data)
+
+	if len(data.Variables) != 3 {
+		t.Fatalf("expected 3 promoted variables, got %d: %#v", len(data.Variables), data.Variables)
+	}
+	if id, ok := data.Variables["id"].(string); !ok || id != "123" {
+		t.Fatalf("expected id variable to be string \"123\", got %#v", data.Variables["id"])
+	}
+	if count, ok := data.Variables["count"].(int64); !ok || count != 5 {
+		t.Fatalf("expected count variable to be int64(5), got %#v", data.Variables["count"])
+	}
+	if active, ok := data.Variables["active"].(bool); !ok || active != true {
+		t.Fatalf("expected active variable to be bool(true), got %#v", data.Variables["active"])
+	}
+	if data.Query == `query { user(id: "123", count: 5, active: true) { name } }` {
+		t.Fatal("expected query to be rewritten to reference variables")
+	}
+}
+
+func TestPromoteGraphQLVariablesSkipsExistingVariableRefs(t *testing.T) {
+	data := &GraphQLData{
+		Query:     `query { user(id: $userId) { name } }`,
+		Variables: map[string]interface{}{"userId": "42"},
+	}
+
+	promoteGraphQLVariables(data)
+
+	if len(data.Variables) != 1 {
+		t.Fatalf("expected variables to be untouched, got %#v", data.Variables)
+	}
+}
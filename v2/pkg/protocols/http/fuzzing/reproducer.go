@@ -0,0 +1,249 @@
+package fuzzing
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/morikuni/accessor"
+	"github.com/pkg/errors"
+)
+
+// ReproducerRecord is a single newline-delimited JSON entry written to
+// AnalyzerOptions.ReproducerSink for every transform applied during
+// AnalyzeRequest. It captures enough state to reproduce or triage that one
+// fuzzing iteration without a live scan: the request before mutation, the
+// transform that was applied, and the request exactly as it was handed to
+// the callback.
+type ReproducerRecord struct {
+	// Request is the normalized request before the transform was applied.
+	Request ReproducerRequest `json:"request"`
+	// Transform is the transform applied for this iteration.
+	Transform ReproducerTransform `json:"transform"`
+	// Rendered is the *http.Request built from Request and Transform.
+	Rendered ReproducerRendered `json:"rendered"`
+}
+
+// ReproducerRequest is the pre-mutation view of a NormalizedRequest.
+type ReproducerRequest struct {
+	Method  string              `json:"method"`
+	Scheme  string              `json:"scheme"`
+	Host    string              `json:"host"`
+	Path    string              `json:"path"`
+	Headers map[string][]string `json:"headers,omitempty"`
+	Cookies map[string][]string `json:"cookies,omitempty"`
+	Query   map[string][]string `json:"query,omitempty"`
+	Body    string              `json:"body"`
+}
+
+// ReproducerTransform describes the mutation applied for a single iteration.
+type ReproducerTransform struct {
+	Part     string `json:"part"`
+	Key      string `json:"key"`
+	OldValue string `json:"old_value"`
+	NewValue string `json:"new_value"`
+	Rule     string `json:"rule"`
+}
+
+// ReproducerRendered is the final request as it was dispatched to the
+// AnalyzeRequest callback.
+type ReproducerRendered struct {
+	Method  string              `json:"method"`
+	URL     string              `json:"url"`
+	Headers map[string][]string `json:"headers,omitempty"`
+	Body    string              `json:"body"`
+}
+
+// NewReproducerFileSink opens path for appending, creating it if necessary,
+// and returns it for use as AnalyzerOptions.ReproducerSink. The caller owns
+// the returned file and must close it once the scan finishes.
+func NewReproducerFileSink(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open reproducer sink file")
+	}
+	return file, nil
+}
+
+// ReplayReproducerRecord rebuilds the *http.Request a ReproducerRecord was
+// generated from, byte-for-byte, so a single fuzzing iteration recorded by
+// ReproducerSink can be reproduced without rerunning AnalyzeRequest.
+func ReplayReproducerRecord(record *ReproducerRecord) (*http.Request, error) {
+	var body io.Reader
+	if record.Rendered.Body != "" {
+		body = strings.NewReader(record.Rendered.Body)
+	}
+
+	req, err := http.NewRequest(record.Rendered.Method, record.Rendered.URL, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not rebuild request from reproducer record")
+	}
+	for key, values := range record.Rendered.Headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if record.Rendered.Body != "" {
+		req.ContentLength = int64(len(record.Rendered.Body))
+	}
+	return req, nil
+}
+
+// writeReproducerRecord serializes a ReproducerRecord for the current
+// transform and request pair to o.ReproducerSink as a single line of JSON.
+//
+// bodyRecorder holds the bytes of the rendered body, teed off as
+// buildRequestForTransform drained it before dispatch; it may be nil when the
+// request carries no body.
+func (o *AnalyzerOptions) writeReproducerRecord(req *NormalizedRequest, transform *Transform, rendered *http.Request, bodyRecorder *bytes.Buffer) error {
+	var renderedBody string
+	if bodyRecorder != nil {
+		renderedBody = bodyRecorder.String()
+	}
+
+	record := &ReproducerRecord{
+		Request: ReproducerRequest{
+			Method:  req.Method,
+			Scheme:  req.Scheme,
+			Host:    req.Host,
+			Path:    req.Path,
+			Headers: map[string][]string(req.Headers),
+			Cookies: map[string][]string(req.Cookies),
+			Query:   map[string][]string(req.QueryValues),
+			Body:    reproducerRequestBody(req),
+		},
+		Transform: ReproducerTransform{
+			Part:     transform.Part,
+			Key:      transform.Key,
+			OldValue: oldValueForTransform(req, transform),
+			NewValue: transform.Value,
+			Rule:     transform.Rule,
+		},
+		Rendered: ReproducerRendered{
+			Method:  rendered.Method,
+			URL:     rendered.URL.String(),
+			Headers: map[string][]string(rendered.Header),
+			Body:    renderedBody,
+		},
+	}
+
+	enc := jsoniter.NewEncoder(o.ReproducerSink)
+	if err := enc.Encode(record); err != nil {
+		return errors.Wrap(err, "could not write reproducer record")
+	}
+	return nil
+}
+
+// reproducerRequestBody serializes whichever typed body field req carries
+// into the string a ReproducerRecord should store as the pre-mutation body.
+// req.Body itself is cleared in favor of a typed field for the majority of
+// real fuzz targets (JSON, XML, form and GraphQL bodies, and compressed JSON
+// and form bodies decoded by decodeCompressedBody), so falling back to
+// req.Body alone would leave reproducer.request.body empty for exactly those
+// cases. The first populated field wins, checked in the order multipart,
+// form, JSON, XML, GraphQL, then req.Body; buildRequestForTransform gives
+// precedence the other way round, so the two pick the same body only when
+// req carries a single body representation.
+func reproducerRequestBody(req *NormalizedRequest) string {
+	switch {
+	case len(req.MultipartBody) > 0:
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		for k, v := range req.MultipartBody {
+			if v.Filename != "" {
+				if fileWriter, err := writer.CreateFormFile(k, v.Filename); err == nil {
+					fileWriter.Write([]byte(v.Value))
+				}
+				continue
+			}
+			_ = writer.WriteField(k, v.Value)
+		}
+		writer.Close()
+		return body.String()
+	case len(req.FormData) > 0:
+		return req.FormData.Encode()
+	case req.JSONData != nil:
+		data, err := jsoniter.Marshal(req.JSONData)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	case len(req.XMLData) > 0:
+		buffer := &bytes.Buffer{}
+		if err := req.XMLData.XmlWriter(buffer); err != nil {
+			return ""
+		}
+		return buffer.String()
+	case req.GraphQLData != nil:
+		payload := map[string]interface{}{
+			"query":     req.GraphQLData.Query,
+			"variables": req.GraphQLData.Variables,
+		}
+		if req.GraphQLData.OperationName != "" {
+			payload["operationName"] = req.GraphQLData.OperationName
+		}
+		data, err := jsoniter.Marshal(payload)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	default:
+		return req.Body
+	}
+}
+
+// oldValueForTransform returns the value a body transform's key held before
+// the transform was applied, looked up in req's multipart, form, JSON and
+// XML body. It returns an empty string for transforms of any other part, for
+// GraphQL bodies, and when the key cannot be found.
+func oldValueForTransform(req *NormalizedRequest, transform *Transform) string {
+	if transform.Part != "body" {
+		return ""
+	}
+
+	for k, v := range req.MultipartBody {
+		if strings.EqualFold(transform.Key, k) {
+			return v.Value
+		}
+	}
+	for k, v := range req.FormData {
+		if strings.EqualFold(transform.Key, k) && len(v) > 0 {
+			return v[0]
+		}
+	}
+	if req.JSONData != nil {
+		if value, ok := accessorOldValue(req.JSONData, transform.Key); ok {
+			return value
+		}
+	}
+	if len(req.XMLData) > 0 {
+		if value, ok := accessorOldValue(req.XMLData, transform.Key); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// accessorOldValue resolves key against data using the same accessor path
+// syntax the body analyzers use to apply transforms, returning the value
+// found there, if any.
+func accessorOldValue(data interface{}, key string) (string, bool) {
+	acc, err := accessor.NewAccessor(data)
+	if err != nil {
+		return "", false
+	}
+	path, err := accessor.ParsePath(key)
+	if err != nil {
+		return "", false
+	}
+	value, err := acc.Get(path)
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%v", value), true
+}
@@ -0,0 +1,342 @@
+package fuzzing
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi2"
+	"github.com/getkin/kin-openapi/openapi2conv"
+	"github.com/getkin/kin-openapi/openapi3"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+)
+
+// Schema wraps a parsed API document used to restrict fuzzing to the
+// injection points an OpenAPI 3 or Swagger 2 definition actually declares for
+// a request's method and path. Swagger 2 documents are converted to OpenAPI
+// 3 on load so the rest of the analyzer only deals with one shape.
+type Schema struct {
+	doc *openapi3.T
+}
+
+// LoadSchemaFromFile loads an OpenAPI 3 or Swagger 2 document from path.
+func LoadSchemaFromFile(path string) (*Schema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read schema file")
+	}
+	return newSchema(data)
+}
+
+// LoadSchemaFromURL loads an OpenAPI 3 or Swagger 2 document from a URL.
+func LoadSchemaFromURL(url string) (*Schema, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not fetch schema")
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read schema response")
+	}
+	return newSchema(data)
+}
+
+// newSchema parses data as an OpenAPI 3 document, falling back to Swagger 2
+// with a conversion to OpenAPI 3 when that fails.
+//
+// A document that parses as OpenAPI 3 but fails doc.Validate (a missing
+// parameter declaration for a path template variable is a common way to
+// trigger this) falls through to the Swagger2 attempt too, since the two
+// formats can't be told apart from a parse error alone. That's worth a
+// warning: reinterpreting an OpenAPI3-shaped document as Swagger2 usually
+// "succeeds" with most of the operation's parameters silently dropped,
+// rather than failing outright.
+func newSchema(data []byte) (*Schema, error) {
+	doc, loadErr := openapi3.NewLoader().LoadFromData(data)
+	var validateErr error
+	if loadErr == nil {
+		if validateErr = doc.Validate(context.Background()); validateErr == nil {
+			return &Schema{doc: doc}, nil
+		}
+		gologger.Warning().Msgf("Schema parsed as OpenAPI3 but failed validation, falling back to Swagger2: %s\n", validateErr)
+	}
+
+	var swagger openapi2.T
+	if swaggerErr := jsoniter.Unmarshal(data, &swagger); swaggerErr != nil {
+		if loadErr != nil {
+			return nil, errors.Wrap(loadErr, "could not parse schema as openapi3 or swagger2")
+		}
+		return nil, errors.Wrap(validateErr, "schema failed openapi3 validation and could not be parsed as swagger2")
+	}
+	converted, err := openapi2conv.ToV3(&swagger)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not convert swagger2 schema to openapi3")
+	}
+	return &Schema{doc: converted}, nil
+}
+
+// matchOperation returns the operation this schema declares for method and
+// path, resolving the path against every path template the document has.
+func (s *Schema) matchOperation(method, path string) (*openapi3.Operation, bool) {
+	_, operation, ok := s.matchOperationTemplate(method, path)
+	return operation, ok
+}
+
+// matchOperationTemplate is matchOperation plus the path template that
+// matched, so callers that need to know which segment a path parameter
+// occupies (substitutePathParam) don't have to re-walk s.doc.Paths.
+func (s *Schema) matchOperationTemplate(method, path string) (template string, operation *openapi3.Operation, ok bool) {
+	if s == nil || s.doc == nil {
+		return "", nil, false
+	}
+	for candidate, item := range s.doc.Paths {
+		if _, matched := matchSchemaPath(candidate, path); !matched {
+			continue
+		}
+		if op := item.GetOperation(strings.ToUpper(method)); op != nil {
+			return candidate, op, true
+		}
+	}
+	return "", nil, false
+}
+
+// substitutePathParam returns path with the concrete segment bound to the
+// schema's {param} placeholder replaced by value, resolving the template
+// the same way matchOperation does. It returns ok=false when no operation
+// matches method and path, or the matched template doesn't declare param as
+// a path segment.
+func (s *Schema) substitutePathParam(method, path, param, value string) (string, bool) {
+	template, _, ok := s.matchOperationTemplate(method, path)
+	if !ok {
+		return "", false
+	}
+
+	templateParts := strings.Split(strings.Trim(template, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(templateParts) != len(pathParts) {
+		return "", false
+	}
+
+	found := false
+	for i, part := range templateParts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") && strings.Trim(part, "{}") == param {
+			pathParts[i] = value
+			found = true
+		}
+	}
+	if !found {
+		return "", false
+	}
+	return "/" + strings.Join(pathParts, "/"), true
+}
+
+// allowedInjectionPoints returns, per request part, the parameter and
+// request body property names this schema declares for the operation
+// matching method and path, along with whether a matching operation was
+// found at all. Parts are keyed the same way AnalyzerOptions.Parts and
+// Transform.Part are ("query-values", "headers", "cookies", "body"), so
+// restrictTransformsToSchema can check a transform's key against only the
+// location the schema actually declared it in.
+func (s *Schema) allowedInjectionPoints(method, path string) (map[string]map[string]bool, bool) {
+	operation, ok := s.matchOperation(method, path)
+	if !ok {
+		return nil, false
+	}
+
+	allowed := make(map[string]map[string]bool)
+	allow := func(part, name string) {
+		if allowed[part] == nil {
+			allowed[part] = make(map[string]bool)
+		}
+		allowed[part][name] = true
+	}
+
+	for _, param := range operation.Parameters {
+		if param.Value == nil {
+			continue
+		}
+		if part := schemaParameterPart(param.Value.In); part != "" {
+			allow(part, param.Value.Name)
+		}
+	}
+	if operation.RequestBody != nil && operation.RequestBody.Value != nil {
+		for _, media := range operation.RequestBody.Value.Content {
+			if media.Schema == nil || media.Schema.Value == nil {
+				continue
+			}
+			for property := range media.Schema.Value.Properties {
+				allow("body", property)
+			}
+		}
+	}
+	return allowed, true
+}
+
+// matchSchemaPath matches an OpenAPI path template such as
+// "/users/{id}/orders/{orderId}" against a concrete request path, returning
+// the resolved path parameters when it matches.
+func matchSchemaPath(template, path string) (map[string]string, bool) {
+	templateParts := strings.Split(strings.Trim(template, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(templateParts) != len(pathParts) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, part := range templateParts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			params[strings.Trim(part, "{}")] = pathParts[i]
+			continue
+		}
+		if part != pathParts[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// restrictTransformsToSchema drops transforms that target a key the schema
+// does not declare, for that transform's own part, for the matched
+// operation, keeping path transforms untouched since path parameters are
+// already resolved by matchSchemaPath.
+func (o *AnalyzerOptions) restrictTransformsToSchema(req *NormalizedRequest, transforms []*Transform) []*Transform {
+	if o.Schema == nil {
+		return transforms
+	}
+	allowed, matched := o.Schema.allowedInjectionPoints(req.Method, req.Path)
+	if !matched {
+		return transforms
+	}
+
+	restricted := make([]*Transform, 0, len(transforms))
+	for _, transform := range transforms {
+		if transform.Part == "path" || allowed[transform.Part][transform.Key] {
+			restricted = append(restricted, transform)
+		}
+	}
+	return restricted
+}
+
+// enrichTransformsFromSchema adds transforms for schema-declared query
+// parameters and request body leaf properties the live traffic didn't
+// happen to populate, so a spec file alone is enough to fuzz an endpoint.
+// Each parameter/property's type, format, enum and pattern pick a
+// type-appropriate payload instead of blindly reusing every rule; fields
+// marked readOnly are skipped since the server never accepts them.
+func (o *AnalyzerOptions) enrichTransformsFromSchema(req *NormalizedRequest, transforms []*Transform) []*Transform {
+	if o.Schema == nil {
+		return transforms
+	}
+	operation, ok := o.Schema.matchOperation(req.Method, req.Path)
+	if !ok {
+		return transforms
+	}
+
+	existing := make(map[string]bool, len(transforms))
+	for _, transform := range transforms {
+		existing[transform.Part+":"+transform.Key] = true
+	}
+
+	var enriched []*Transform
+	for _, param := range operation.Parameters {
+		if param.Value == nil || param.Value.Schema == nil {
+			continue
+		}
+		part := schemaParameterPart(param.Value.In)
+		if part == "" || existing[part+":"+param.Value.Name] {
+			continue
+		}
+		enriched = append(enriched, schemaLeafTransforms(part, param.Value.Name, param.Value.Schema, existing)...)
+	}
+
+	if operation.RequestBody != nil && operation.RequestBody.Value != nil {
+		for _, media := range operation.RequestBody.Value.Content {
+			if media.Schema == nil {
+				continue
+			}
+			enriched = append(enriched, schemaLeafTransforms("body", "", media.Schema, existing)...)
+		}
+	}
+	return append(transforms, enriched...)
+}
+
+// schemaParameterPart maps an OpenAPI parameter location to the Part string
+// AnalyzerOptions.Parts uses for the same request part.
+func schemaParameterPart(in string) string {
+	switch in {
+	case "query":
+		return "query-values"
+	case "header":
+		return "headers"
+	case "cookie":
+		return "cookies"
+	case "path":
+		return "path"
+	default:
+		return ""
+	}
+}
+
+// schemaLeafTransforms walks schema recursively, building an accessor-path
+// key (matching accessor.ParsePath syntax) for every leaf property under
+// prefix, and emits one transform per type-appropriate payload for each leaf
+// that doesn't already have a transform.
+func schemaLeafTransforms(part, prefix string, schema *openapi3.SchemaRef, existing map[string]bool) []*Transform {
+	if schema == nil || schema.Value == nil || schema.Value.ReadOnly {
+		return nil
+	}
+	value := schema.Value
+
+	if len(value.Properties) > 0 {
+		var transforms []*Transform
+		for name, property := range value.Properties {
+			path := name
+			if prefix != "" {
+				path = prefix + "." + name
+			}
+			transforms = append(transforms, schemaLeafTransforms(part, path, property, existing)...)
+		}
+		return transforms
+	}
+
+	if prefix == "" || existing[part+":"+prefix] {
+		return nil
+	}
+	return schemaPayloadTransforms(part, prefix, value)
+}
+
+// schemaPayloadTransforms picks payloads appropriate to a leaf schema's
+// type/format/enum and returns one *Transform per payload variant.
+func schemaPayloadTransforms(part, key string, schema *openapi3.Schema) []*Transform {
+	var payloads []string
+	switch {
+	case len(schema.Enum) > 0:
+		for _, value := range schema.Enum {
+			payloads = append(payloads, fmt.Sprintf("%v", value))
+		}
+	case schema.Format == "uri" || schema.Format == "url":
+		payloads = []string{"http://169.254.169.254/latest/meta-data/", "file:///etc/passwd"}
+	case schema.Format == "path":
+		payloads = []string{"../../../../etc/passwd"}
+	case schema.Type == "string":
+		payloads = []string{`' OR '1'='1`, "<script>alert(1)</script>"}
+	case schema.Type == "integer" || schema.Type == "number":
+		payloads = []string{"-1", "99999999999999999999"}
+	case schema.Type == "boolean":
+		payloads = []string{"true", "false"}
+	default:
+		return nil
+	}
+
+	transforms := make([]*Transform, 0, len(payloads))
+	for _, payload := range payloads {
+		transforms = append(transforms, &Transform{Part: part, Key: key, Value: payload, Rule: "schema"})
+	}
+	return transforms
+}
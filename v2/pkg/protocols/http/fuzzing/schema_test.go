@@ -0,0 +1,69 @@
+package fuzzing
+
+import "testing"
+
+const testOpenAPI3Doc = `{
+  "openapi": "3.0.0",
+  "info": {"title": "test", "version": "1.0"},
+  "paths": {
+    "/users/{id}/orders/{orderId}": {
+      "get": {
+        "parameters": [
+          {"name": "id", "in": "path", "required": true, "schema": {"type": "string"}},
+          {"name": "orderId", "in": "path", "required": true, "schema": {"type": "string"}}
+        ],
+        "responses": {"200": {"description": "ok"}}
+      }
+    }
+  }
+}`
+
+func mustLoadTestSchema(t *testing.T) *Schema {
+	t.Helper()
+	schema, err := newSchema([]byte(testOpenAPI3Doc))
+	if err != nil {
+		t.Fatalf("could not load test schema: %s", err)
+	}
+	return schema
+}
+
+func TestSchemaSubstitutePathParam(t *testing.T) {
+	schema := mustLoadTestSchema(t)
+
+	fuzzed, ok := schema.substitutePathParam("GET", "/users/123/orders/456", "id", "../../etc/passwd")
+	if !ok {
+		t.Fatal("expected substitutePathParam to match the declared path parameter")
+	}
+	if want := "/users/../../etc/passwd/orders/456"; fuzzed != want {
+		t.Fatalf("substitutePathParam = %q, want %q", fuzzed, want)
+	}
+
+	if _, ok := schema.substitutePathParam("GET", "/users/123/orders/456", "missing", "x"); ok {
+		t.Fatal("expected substitutePathParam to fail for a param the schema doesn't declare")
+	}
+	if _, ok := schema.substitutePathParam("GET", "/no/such/path", "id", "x"); ok {
+		t.Fatal("expected substitutePathParam to fail when no operation matches the path")
+	}
+}
+
+func TestBuildRequestForTransformSubstitutesSchemaPathParam(t *testing.T) {
+	req := &NormalizedRequest{
+		Method: "GET",
+		Scheme: "https",
+		Host:   "example.com",
+		Path:   "/users/123/orders/456",
+	}
+	options := &AnalyzerOptions{Schema: mustLoadTestSchema(t)}
+	transform := &Transform{Part: "path", Key: "orderId", Value: "../../etc/passwd", Rule: "schema"}
+
+	rendered, err := options.buildRequestForTransform(req, transform, "", 0)
+	if err != nil {
+		t.Fatalf("buildRequestForTransform returned error: %s", err)
+	}
+	if want := "/users/123/orders/../../etc/passwd"; rendered.URL.Path != want {
+		t.Fatalf("rendered.URL.Path = %q, want %q", rendered.URL.Path, want)
+	}
+	if req.Path != "/users/123/orders/456" {
+		t.Fatalf("req.Path was mutated: %q", req.Path)
+	}
+}